@@ -0,0 +1,162 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// runQuery executes the oracle query for mode and pos and records it
+// in the history, so that both serveQuery and serveQueryJSON make
+// their queries shareable via /history and /q/{id} the same way.
+func runQuery(mode, pos string) (interface{}, HistoryEntry, error) {
+	if *verbose {
+		fmt.Printf("query: mode=%s pos=%s\n", mode, pos)
+	}
+
+	mutex.Lock()
+	result, err := ora.Query(mode, pos)
+	mutex.Unlock()
+	if err != nil {
+		return nil, HistoryEntry{}, err
+	}
+
+	serial := result.Serial()
+	entry, err := recordHistory(mode, pos, serial)
+	if err != nil && *verbose {
+		fmt.Printf("record history: %v\n", err)
+	}
+	return serial, entry, nil
+}
+
+// queryTemplate renders an oracle result as an HTML page for the
+// browser. It's a minimal placeholder for pythia's real query view,
+// which isn't part of this chunk.
+var queryTemplate = template.Must(template.New("query").Parse(`<!DOCTYPE html>
+<html>
+<head><title>pythia: {{.Mode}} {{.Pos}}</title></head>
+<body>
+<pre>{{.Result}}</pre>
+</body>
+</html>
+`))
+
+// serveQuery runs the oracle query described by the "mode" and "pos"
+// request parameters and renders an HTML page of the result for the
+// browser. It shares its history bookkeeping with serveQueryJSON via
+// runQuery, so a query run interactively in the browser is just as
+// shareable via /history and /q/{id} as one run through the JSON API.
+func serveQuery(w http.ResponseWriter, r *http.Request) {
+	mode := r.FormValue("mode")
+	pos := r.FormValue("pos")
+	if mode == "" || pos == "" {
+		http.Error(w, "both 'mode' and 'pos' parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	serial, _, err := runQuery(mode, pos)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.MarshalIndent(serial, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = queryTemplate.Execute(w, struct {
+		Mode, Pos string
+		Result    string
+	}{mode, pos, string(data)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveQueryJSON is the machine-readable counterpart of serveQuery. It runs
+// the oracle query described by the "mode" and "pos" request parameters
+// and writes the result as JSON, so that editor plugins can drive pythia
+// the same way they drive `oracle -format=json` without scraping the HTML
+// query view. The query always runs against the scope pythia was started
+// with; a "scope" request parameter is not read.
+func serveQueryJSON(w http.ResponseWriter, r *http.Request) {
+	writeCORSHeaders(w)
+	mode := r.FormValue("mode")
+	pos := r.FormValue("pos")
+	if mode == "" || pos == "" {
+		http.Error(w, "both 'mode' and 'pos' parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	serial, entry, err := runQuery(mode, pos)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if entry.ID != "" {
+		w.Header().Set("X-Pythia-Query-Id", entry.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(serial); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveModes reports which oracle query modes are valid for the position
+// given by the "pos" request parameter, by delegating to the oracle's
+// "what" mode.
+func serveModes(w http.ResponseWriter, r *http.Request) {
+	writeCORSHeaders(w)
+	pos := r.FormValue("pos")
+	if pos == "" {
+		http.Error(w, "'pos' parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	mutex.Lock()
+	result, err := ora.Query("what", pos)
+	mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	what := result.Serial().What
+	var modes []string
+	if what != nil {
+		modes = what.Modes
+	}
+	modes = normalizeModes(modes)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(modes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// normalizeModes turns a nil slice of query modes (no modes valid at
+// the queried position) into an empty one, so serveModes always
+// encodes a JSON array instead of null.
+func normalizeModes(modes []string) []string {
+	if modes == nil {
+		return []string{}
+	}
+	return modes
+}
+
+// writeCORSHeaders allows the JSON endpoints to be called from editor
+// plugins running in contexts other than pythia's own origin (e.g. a
+// VS Code webview), which browsers otherwise block by default.
+func writeCORSHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+}
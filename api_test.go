@@ -0,0 +1,39 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWriteCORSHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeCORSHeaders(w)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, "*")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, OPTIONS" {
+		t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, "GET, OPTIONS")
+	}
+}
+
+func TestNormalizeModes(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string
+	}{
+		{in: nil, want: []string{}},
+		{in: []string{}, want: []string{}},
+		{in: []string{"callers", "callees"}, want: []string{"callers", "callees"}},
+	}
+	for _, tt := range tests {
+		if got := normalizeModes(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeModes(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
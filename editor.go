@@ -0,0 +1,151 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var editor = flag.String("editor", "", "Command template to open a position in an editor, e.g. 'code --goto {file}:{line}:{col}'")
+
+// editorTemplates whitelists the command templates -editor may be set
+// to, keyed by the program name they invoke. Only templates built
+// entirely from this list are ever passed to exec.Command, so the
+// -editor flag cannot be abused to run an arbitrary command chosen by
+// whoever can reach /open.
+var editorTemplates = map[string]string{
+	"code":        "code --goto {file}:{line}:{col}",
+	"emacsclient": "emacsclient +{line}:{col} {file}",
+	"vim":         "vim +{line} {file}",
+	"gvim":        "gvim --remote +{line} {file}",
+	"subl":        "subl {file}:{line}:{col}",
+	"atom":        "atom {file}:{line}:{col}",
+}
+
+// editorCookie is the name of the cookie that lets a browser pick its
+// own editor template, overriding the -editor flag for that request.
+const editorCookie = "pythia-editor"
+
+// serveOpen pops the user's editor at the position given by the "pos"
+// request parameter (file:line:col), using the command template
+// configured by -editor (or the editorCookie, if set). It shells out
+// via exec.Command, never a shell, and only ever with a whitelisted
+// template, so the request itself cannot inject extra arguments. If no
+// editor is configured, it falls back to the ordinary browser source
+// view.
+func serveOpen(w http.ResponseWriter, r *http.Request) {
+	if !isLoopback(r) {
+		http.Error(w, "/open is only available from localhost", http.StatusForbidden)
+		return
+	}
+	pos := r.FormValue("pos")
+	file, line, col, err := parsePos(pos)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	template := *editor
+	if c, err := r.Cookie(editorCookie); err == nil && c.Value != "" {
+		template = c.Value
+	}
+	if template == "" {
+		http.Redirect(w, r, "/source?file="+url.QueryEscape(file), http.StatusFound)
+		return
+	}
+
+	name, args, err := editorCommand(template, file, line, col)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := exec.Command(name, args...).Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveEditor lets a browser set or clear its editorCookie, so a
+// reviewer can point pythia at their own editor without restarting the
+// server for everyone who shares it.
+func serveEditor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	template := r.FormValue("editor")
+	if template != "" {
+		if _, _, err := editorCommand(template, "f", 1, 1); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: editorCookie, Value: template, Path: "/"})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isLoopback reports whether r originated from the loopback interface.
+// serveOpen shells out to whatever editor the server operator
+// configured, so it must refuse any request that didn't come from the
+// machine pythia runs on, regardless of what -http is bound to.
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// parsePos splits a "file:line:col" position as used throughout
+// pythia's query results.
+func parsePos(pos string) (file string, line, col int, err error) {
+	parts := strings.Split(pos, ":")
+	if len(parts) != 3 {
+		return "", 0, 0, fmt.Errorf("invalid position %q, want file:line:col", pos)
+	}
+	line, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid line in position %q", pos)
+	}
+	col, err = strconv.Atoi(parts[2])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid column in position %q", pos)
+	}
+	return parts[0], line, col, nil
+}
+
+// editorCommand looks up the whitelisted template for the program named
+// by template's first word and substitutes {file}, {line} and {col}
+// into its remaining words.
+func editorCommand(template, file string, line, col int) (name string, args []string, err error) {
+	words := strings.Fields(template)
+	if len(words) == 0 {
+		return "", nil, fmt.Errorf("empty -editor template")
+	}
+	allowed, ok := editorTemplates[words[0]]
+	if !ok || allowed != template {
+		return "", nil, fmt.Errorf("-editor %q is not a whitelisted template", template)
+	}
+
+	replacer := strings.NewReplacer(
+		"{file}", file,
+		"{line}", strconv.Itoa(line),
+		"{col}", strconv.Itoa(col),
+	)
+	substituted := make([]string, len(words)-1)
+	for i, w := range words[1:] {
+		substituted[i] = replacer.Replace(w)
+	}
+	return words[0], substituted, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditorCommand(t *testing.T) {
+	tests := []struct {
+		template string
+		wantName string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			template: "code --goto {file}:{line}:{col}",
+			wantName: "code",
+			wantArgs: []string{"--goto", "main.go:12:3"},
+		},
+		{
+			template: "vim +{line} {file}",
+			wantName: "vim",
+			wantArgs: []string{"+12", "main.go"},
+		},
+		{
+			template: "",
+			wantErr:  true,
+		},
+		{
+			// Not on the whitelist at all.
+			template: "sh -c {file}",
+			wantErr:  true,
+		},
+		{
+			// Program is whitelisted, but this particular template for
+			// it is not, so a request cannot smuggle extra arguments in.
+			template: "code --goto {file}:{line}:{col} && rm -rf /",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		name, args, err := editorCommand(tt.template, "main.go", 12, 3)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("editorCommand(%q): got nil error, want one", tt.template)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("editorCommand(%q): unexpected error: %v", tt.template, err)
+			continue
+		}
+		if name != tt.wantName || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("editorCommand(%q) = %q, %q, want %q, %q", tt.template, name, args, tt.wantName, tt.wantArgs)
+		}
+	}
+}
+
+func TestParsePos(t *testing.T) {
+	tests := []struct {
+		pos      string
+		wantFile string
+		wantLine int
+		wantCol  int
+		wantErr  bool
+	}{
+		{pos: "main.go:12:3", wantFile: "main.go", wantLine: 12, wantCol: 3},
+		{pos: "main.go:12", wantErr: true},
+		{pos: "main.go:x:3", wantErr: true},
+		{pos: "main.go:12:y", wantErr: true},
+		{pos: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		file, line, col, err := parsePos(tt.pos)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePos(%q): got nil error, want one", tt.pos)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePos(%q): unexpected error: %v", tt.pos, err)
+			continue
+		}
+		if file != tt.wantFile || line != tt.wantLine || col != tt.wantCol {
+			t.Errorf("parsePos(%q) = %q, %d, %d, want %q, %d, %d", tt.pos, file, line, col, tt.wantFile, tt.wantLine, tt.wantCol)
+		}
+	}
+}
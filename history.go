@@ -0,0 +1,265 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryEntry records one oracle query that was run, so it can be
+// revisited later at the stable URL /q/{id} and listed in the history
+// sidebar, letting a reviewer link a colleague to "the callers of X in
+// this build".
+type HistoryEntry struct {
+	ID        string    `json:"id"`
+	Mode      string    `json:"mode"`
+	Pos       string    `json:"pos"`
+	ScopeHash string    `json:"scopeHash"`
+	CmdLine   string    `json:"cmdLine"`
+	Time      time.Time `json:"time"`
+}
+
+var historyMu sync.Mutex
+
+// historyDir returns (creating it if necessary) the directory pythia
+// persists its query history and cached results under.
+func historyDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cache, "pythia")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func historyFile() (string, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.json"), nil
+}
+
+// resultCachePath returns the path the JSON result for a history entry
+// is cached at.
+func resultCachePath(id string) (string, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return "", err
+	}
+	resultsDir := filepath.Join(dir, "results")
+	if err := os.MkdirAll(resultsDir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(resultsDir, id+".json"), nil
+}
+
+// loadHistory reads the persisted history, returning a nil slice
+// (not an error) if none has been recorded yet.
+func loadHistory() ([]HistoryEntry, error) {
+	path, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func saveHistory(entries []HistoryEntry) error {
+	path, err := historyFile()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// scopeHash identifies the current oracle scope, so a cached result can
+// be told apart from one computed against an older build of the same
+// packages.
+func scopeHash() string {
+	mutex.Lock()
+	names := append([]string(nil), files...)
+	mutex.Unlock()
+	sort.Strings(names)
+	h := sha1.New()
+	for _, name := range names {
+		fmt.Fprintln(h, name)
+		if fi, err := fs.Stat(name); err == nil {
+			fmt.Fprintln(h, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// queryID derives the id a (mode, pos, scope) query is addressable at
+// under /q/.
+func queryID(mode, pos, hash string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", mode, pos, hash)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// recordHistory appends an entry for a query to the persisted history
+// and caches its already-serialized JSON result under the entry's id,
+// so that revisiting /q/{id} later can be served from cache while the
+// scope is unchanged.
+func recordHistory(mode, pos string, result interface{}) (HistoryEntry, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	hash := scopeHash()
+	entry := HistoryEntry{
+		ID:        queryID(mode, pos, hash),
+		Mode:      mode,
+		Pos:       pos,
+		ScopeHash: hash,
+		CmdLine:   cmdLine(mode, pos, "json", args),
+		Time:      time.Now(),
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return entry, err
+	}
+	path, err := resultCachePath(entry.ID)
+	if err != nil {
+		return entry, err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return entry, err
+	}
+
+	entries, err := loadHistory()
+	if err != nil {
+		return entry, err
+	}
+	entries = append(removeEntry(entries, entry.ID), entry)
+	return entry, saveHistory(entries)
+}
+
+// removeEntry returns entries with the one whose ID matches id
+// filtered out.
+func removeEntry(entries []HistoryEntry, id string) []HistoryEntry {
+	out := entries[:0]
+	for _, e := range entries {
+		if e.ID != id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// serveHistory lists the persisted query history (GET) or deletes one
+// entry from it (DELETE, entry id given by the "id" parameter).
+func serveHistory(w http.ResponseWriter, r *http.Request) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	switch r.Method {
+	case "GET":
+		entries, err := loadHistory()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(entries)
+	case "DELETE":
+		id := r.FormValue("id")
+		entries, err := loadHistory()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := saveHistory(removeEntry(entries, id)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "GET or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveHistoryQuery serves the query addressed by /q/{id}: from cache
+// when the entry's scope hash still matches the current scope, or by
+// re-executing the oracle query and refreshing both cache and history
+// otherwise.
+func serveHistoryQuery(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/q/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	historyMu.Lock()
+	entries, err := loadHistory()
+	historyMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var entry *HistoryEntry
+	for i := range entries {
+		if entries[i].ID == id {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if entry.ScopeHash == scopeHash() {
+		if path, err := resultCachePath(entry.ID); err == nil {
+			if data, err := ioutil.ReadFile(path); err == nil {
+				w.Write(data)
+				return
+			}
+		}
+	}
+
+	mutex.Lock()
+	result, err := ora.Query(entry.Mode, entry.Pos)
+	mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	serial := result.Serial()
+	if _, err := recordHistory(entry.Mode, entry.Pos, serial); err != nil && *verbose {
+		fmt.Fprintf(os.Stderr, "pythia: record history: %v\n", err)
+	}
+	json.NewEncoder(w).Encode(serial)
+}
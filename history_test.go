@@ -0,0 +1,66 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestQueryID(t *testing.T) {
+	a := queryID("callers", "main.go:1:1", "abc")
+	b := queryID("callers", "main.go:1:1", "abc")
+	if a != b {
+		t.Errorf("queryID is not deterministic: %q != %q", a, b)
+	}
+
+	variants := [][3]string{
+		{"callees", "main.go:1:1", "abc"},
+		{"callers", "main.go:2:1", "abc"},
+		{"callers", "main.go:1:1", "def"},
+	}
+	for _, v := range variants {
+		if id := queryID(v[0], v[1], v[2]); id == a {
+			t.Errorf("queryID(%q, %q, %q) collided with queryID(\"callers\", \"main.go:1:1\", \"abc\")", v[0], v[1], v[2])
+		}
+	}
+}
+
+func TestScopeHashOrderIndependent(t *testing.T) {
+	orig := files
+	defer func() { files = orig }()
+
+	files = []string{"b.go", "a.go", "c.go"}
+	h1 := scopeHash()
+	files = []string{"a.go", "c.go", "b.go"}
+	h2 := scopeHash()
+	if h1 != h2 {
+		t.Errorf("scopeHash depends on the order of files: %q != %q", h1, h2)
+	}
+
+	files = []string{"a.go", "b.go"}
+	h3 := scopeHash()
+	if h3 == h1 {
+		t.Errorf("scopeHash did not change for a different scope")
+	}
+}
+
+func TestRemoveEntry(t *testing.T) {
+	newEntries := func() []HistoryEntry {
+		return []HistoryEntry{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	}
+
+	got := removeEntry(newEntries(), "2")
+	want := []string{"1", "3"}
+	if len(got) != len(want) {
+		t.Fatalf("removeEntry: got %d entries, want %d", len(got), len(want))
+	}
+	for i, e := range got {
+		if e.ID != want[i] {
+			t.Errorf("removeEntry: got ID %q at index %d, want %q", e.ID, i, want[i])
+		}
+	}
+
+	if got := removeEntry(newEntries(), "missing"); len(got) != 3 {
+		t.Errorf("removeEntry with an unknown id removed entries: got %d, want 3", len(got))
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"code.google.com/p/go.tools/go/types"
+	"code.google.com/p/go.tools/importer"
+	"code.google.com/p/go.tools/pointer"
+)
+
+var linksFlag = flag.Bool("links", true, "Precompute cross-reference hyperlinks for the source view")
+
+// Pos is the JSON-friendly position a Link points to.
+type Pos struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+// Link describes a hyperlink from a byte-offset range in a source file
+// to the position (or, for dynamic dispatch, positions) it refers to.
+type Link struct {
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Kind    string `json:"kind"` // "ident" or "interface-call"
+	Targets []Pos  `json:"targets"`
+}
+
+// Link.Kind values.
+const (
+	kindIdent         = "ident"
+	kindInterfaceCall = "interface-call"
+)
+
+// links holds the per-file hyperlink overlays for the program that is
+// currently in scope, keyed by the same file names as the "files"
+// global. It is rebuilt by rebuildLinks each time the scope is (re)loaded.
+var links map[string][]Link
+
+// rebuildLinks runs a whole-program pointer and type analysis over
+// iprog and produces per-token hyperlink overlays: identifiers link to
+// their definition, and interface method calls link to every concrete
+// type the pointer analysis found reachable at that call site. The
+// overlays are written as a JSON side-file per source file under
+// linksCacheDir, keyed by byte offset, for the source-view template to
+// consume without a round-trip per click.
+//
+// It is invoked from load, under mutex, so it always runs against a
+// consistent iprog/ora pair.
+func rebuildLinks() error {
+	if !*linksFlag {
+		return nil
+	}
+
+	result, err := pointerAnalyze(iprog)
+	if err != nil {
+		return err
+	}
+
+	overlays := make(map[string][]Link)
+	for _, pkg := range iprog.AllPackages {
+		for _, file := range pkg.Files {
+			fileLinks := identLinks(pkg, file)
+			fileLinks = append(fileLinks, callLinks(pkg, file, result)...)
+			name := iprog.Fset.File(file.Pos()).Name()
+			overlays[name] = fileLinks
+		}
+	}
+
+	if err := writeLinkFiles(overlays); err != nil {
+		return err
+	}
+	pruneLinkFiles(links, overlays)
+	links = overlays
+	return nil
+}
+
+// pruneLinkFiles removes the side-files of entries that were present
+// in the previous overlay set but have dropped out of the new one
+// (e.g. a renamed or deleted source file), so that linksCacheDir
+// doesn't grow without bound over a long live-reload session.
+func pruneLinkFiles(previous, current map[string][]Link) {
+	dir, err := linksCacheDir()
+	if err != nil {
+		return
+	}
+	for name := range previous {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		os.Remove(filepath.Join(dir, linkFileName(name)))
+	}
+}
+
+// pointerAnalyze runs the pointer analysis needed to resolve dynamic
+// dispatch at interface method call sites. It mirrors the analysis
+// godoc/analysis performs over a whole program.
+func pointerAnalyze(iprog *importer.Program) (*pointer.Result, error) {
+	var mains []*importer.PackageInfo
+	for _, pkg := range iprog.AllPackages {
+		if pkg.Pkg.Name() == "main" && pkg.Pkg.Scope().Lookup("main") != nil {
+			mains = append(mains, pkg)
+		}
+	}
+	if len(mains) == 0 {
+		// No main package in scope (e.g. a library): skip dynamic
+		// dispatch resolution but still produce identifier links.
+		return nil, nil
+	}
+	config := &pointer.Config{
+		Mains:          mains,
+		BuildCallGraph: true,
+	}
+	return pointer.Analyze(config)
+}
+
+// identLinks walks the identifiers of file and links each use to the
+// position of its declaration, using the type-checker's Uses/Defs
+// recorded for pkg.
+func identLinks(pkg *importer.PackageInfo, file *ast.File) []Link {
+	var out []Link
+	ast.Inspect(file, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		obj := pkg.Uses[id]
+		if obj == nil {
+			obj = pkg.Defs[id]
+		}
+		if obj == nil || !obj.Pos().IsValid() {
+			return true
+		}
+		out = append(out, Link{
+			Start:   iprog.Fset.Position(id.Pos()).Offset,
+			End:     iprog.Fset.Position(id.End()).Offset,
+			Kind:    kindIdent,
+			Targets: []Pos{posOf(obj.Pos())},
+		})
+		return true
+	})
+	return out
+}
+
+// callLinks walks the call expressions of file and, where the pointer
+// analysis resolved concrete callees for a call dispatched through an
+// interface-typed receiver, records a link to every one of them.
+// Calls through a concrete receiver are statically resolved, so they
+// are already covered by identLinks via the callee identifier; only
+// interface method calls genuinely need the pointer analysis's
+// resolution of the dynamic dispatch.
+func callLinks(pkg *importer.PackageInfo, file *ast.File, result *pointer.Result) []Link {
+	if result == nil {
+		return nil
+	}
+	var out []Link
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		selection, ok := pkg.Selections[sel]
+		if !ok || selection.Kind() != types.MethodVal {
+			return true
+		}
+		if _, isInterface := selection.Recv().Underlying().(*types.Interface); !isInterface {
+			return true
+		}
+		targets := dynamicCallees(result, call)
+		if len(targets) == 0 {
+			return true
+		}
+		out = append(out, Link{
+			Start:   iprog.Fset.Position(sel.Sel.Pos()).Offset,
+			End:     iprog.Fset.Position(sel.Sel.End()).Offset,
+			Kind:    kindInterfaceCall,
+			Targets: targets,
+		})
+		return true
+	})
+	return out
+}
+
+// dynamicCallees returns the declaration positions of every function
+// the pointer analysis's call graph says call could dispatch to.
+func dynamicCallees(result *pointer.Result, call *ast.CallExpr) []Pos {
+	var targets []Pos
+	for _, node := range result.CallGraph.Nodes() {
+		for _, edge := range node.Out {
+			if edge.Site == nil || edge.Site.Pos() != call.Pos() {
+				continue
+			}
+			if fn := edge.Callee.Func; fn != nil {
+				targets = append(targets, posOf(fn.Pos()))
+			}
+		}
+	}
+	return targets
+}
+
+// posOf converts a go/token.Pos to the JSON-friendly Pos understood by
+// the front-end, resolving file/line/col via the shared Fset.
+func posOf(p token.Pos) Pos {
+	position := iprog.Fset.Position(p)
+	return Pos{File: position.Filename, Line: position.Line, Col: position.Column}
+}
+
+// linksCacheDir returns the directory link overlay side-files are
+// written to, creating it if necessary. It is scoped by pid, so two
+// pythia instances analysing overlapping files (e.g. the same checkout
+// imported under different -tags) never write to the same side-file.
+func linksCacheDir() (string, error) {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("pythia-links-%d", os.Getpid()))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// writeLinkFiles writes one JSON side-file per source file into
+// linksCacheDir, named after a hash of the file's path. linksCacheDir
+// is itself scoped by pid, so concurrent pythia instances never write
+// to the same path.
+func writeLinkFiles(overlays map[string][]Link) error {
+	dir, err := linksCacheDir()
+	if err != nil {
+		return err
+	}
+	for name, fileLinks := range overlays {
+		data, err := json.Marshal(fileLinks)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, linkFileName(name))
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveLinks serves the precomputed hyperlink overlay for the scope
+// file named by the "file" request parameter, reading the side-file
+// rebuildLinks wrote under linksCacheDir, so the source view can render
+// cross-reference links without a round-trip per click. The in-memory
+// links map is only consulted to check that name has an overlay.
+func serveLinks(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("file")
+	mutex.Lock()
+	_, ok := links[name]
+	mutex.Unlock()
+	if !ok {
+		http.Error(w, "no links for "+name, http.StatusNotFound)
+		return
+	}
+
+	dir, err := linksCacheDir()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, linkFileName(name)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
+
+// linkFileName derives the side-file name for a source file path.
+func linkFileName(sourcePath string) string {
+	h := fnv.New64a()
+	h.Write([]byte(sourcePath))
+	return fmt.Sprintf("%x.json", h.Sum64())
+}
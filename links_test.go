@@ -0,0 +1,19 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestLinkFileName(t *testing.T) {
+	a := linkFileName("/src/pkg/a.go")
+	b := linkFileName("/src/pkg/a.go")
+	if a != b {
+		t.Errorf("linkFileName is not deterministic: %q != %q", a, b)
+	}
+
+	if c := linkFileName("/src/pkg/b.go"); c == a {
+		t.Errorf("linkFileName collided for two different paths: %q", c)
+	}
+}
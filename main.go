@@ -8,7 +8,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"go/build"
 	"go/token"
 	"net"
 	"net/http"
@@ -56,6 +55,34 @@ The -http flag specifies the HTTP service address (e.g., ':6060').
 The -tags flag specifies comma separated tags to use when importing
 code (e.g., 'foo,!darwin').
 
+The -zip flag points pythia at a zip archive of a corpus to serve
+instead of the local filesystem, with -index giving the root path
+within the archive the scope is mounted at (e.g.,
+'-zip=corpus.zip -index=/go'). This lets pythia ship as a single
+binary serving a read-only snapshot of a codebase.
+
+The -links flag (on by default) precomputes cross-reference
+hyperlinks for the source view: identifiers link to their
+declaration and interface method calls link to every concrete type
+the pointer analysis found reachable at that call site. Set it to
+'false' to skip the analysis pass on large scopes.
+
+The -editor flag sets a command template pythia uses to open a
+position in your editor, e.g.
+'-editor=code --goto {file}:{line}:{col}' or
+'-editor=emacsclient +{line}:{col} {file}'. A request from localhost
+to /open?pos=file:line:col then opens that position in the editor.
+If unset, /open redirects to the in-browser source view of the file.
+The query result page itself does not link to /open.
+
+Every query is cached and recorded in a history under
+os.UserCacheDir()/pythia, keyed by mode, position and a hash of the
+current scope. /history lists and deletes past queries, and every
+result is addressable at a stable URL /q/{id} that replays from
+cache while the scope hash still matches, and re-runs the oracle
+otherwise. This makes it possible to link a colleague to, say, "the
+callers of X in this build".
+
 The -open flag determines, whether the application should try to
 open the browser. It is set to 'true' by default. If set to 'false'
 the browser will not be launched.
@@ -63,6 +90,12 @@ the browser will not be launched.
 The -v flag enables verbose mode, in which every incoming query
 to the oracle is logged to the standard output.
 
+Pythia watches the files in its scope and automatically rebuilds the
+importer program and oracle when one of them changes on disk, so
+there is no need to restart after an edit. Open browser tabs are
+notified over /events and refresh themselves; editor plugins can
+force an immediate rebuild by POSTing to /reload.
+
 Examples:
 
 Start pythia with the scope of package oracle:
@@ -90,27 +123,10 @@ func main() {
 		os.Exit(2)
 	}
 
-	var err error
-	settings := build.Default
-	settings.BuildTags = strings.Split(*tags, ",")
-	conf := importer.Config{
-		Build:         &settings,
-		SourceImports: true,
-	}
-	_, err = conf.FromArgs(args)
-	if err != nil {
+	if err := load(); err != nil {
 		exitError(err)
 	}
-	iprog, err = conf.Load()
-	if err != nil {
-		exitError(err)
-	}
-	ora, err = oracle.New(iprog, nil, false)
-	if err != nil {
-		exitError(err)
-	}
-	files = scopeFiles(iprog)
-	packages = sortedPackages(iprog)
+	go watchScope(files)
 
 	registerHandlers()
 
@@ -133,10 +149,57 @@ func registerHandlers() {
 	http.HandleFunc("/source", serveSource)
 	http.HandleFunc("/file", serveFile)
 	http.HandleFunc("/query", serveQuery)
+	http.HandleFunc("/api/query", serveQueryJSON)
+	http.HandleFunc("/api/modes", serveModes)
+	http.HandleFunc("/events", serveEvents)
+	http.HandleFunc("/reload", serveReload)
+	http.HandleFunc("/open", serveOpen)
+	http.HandleFunc("/editor", serveEditor)
+	http.HandleFunc("/history", serveHistory)
+	http.HandleFunc("/q/", serveHistoryQuery)
+	http.HandleFunc("/api/links", serveLinks)
 	staticPrefix := "/static/"
 	http.Handle(staticPrefix, http.StripPrefix(staticPrefix, http.HandlerFunc(serveStatic)))
 }
 
+// load (re-)builds the importer program and oracle for the current
+// command-line arguments and tags, and atomically swaps them into the
+// package-level globals. It is called once at startup and again
+// whenever the scope changes on disk; it holds mutex only while
+// swapping the globals in and rebuilding the link overlays.
+func load() error {
+	settings, err := scopeBuildContext()
+	if err != nil {
+		return err
+	}
+	conf := importer.Config{
+		Build:         settings,
+		SourceImports: true,
+	}
+	if _, err := conf.FromArgs(args); err != nil {
+		return err
+	}
+	prog, err := conf.Load()
+	if err != nil {
+		return err
+	}
+	o, err := oracle.New(prog, nil, false)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	iprog = prog
+	ora = o
+	files = scopeFiles(iprog)
+	packages = sortedPackages(iprog)
+	if err := rebuildLinks(); err != nil {
+		return err
+	}
+	return nil
+}
+
 // byPath makes a slice of package infos sortable by package path.
 type byPath []*importer.PackageInfo
 
@@ -0,0 +1,183 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// subscribers holds the notification channels of clients waiting on the
+// /events endpoint for a reload to happen.
+var (
+	subscribers   = make(map[chan struct{}]bool)
+	subscribersMu sync.Mutex
+)
+
+// reloadDebounce is how long watchScope waits for the filesystem to go
+// quiet before reloading. A single save routinely fires several
+// fsnotify events in a row (e.g. a temp-file write followed by a
+// rename), and each reload runs a full conf.Load plus, with -links,
+// a whole-program pointer analysis while holding mutex, so coalescing
+// a burst into one reload avoids repeatedly blocking every other
+// handler back to back.
+const reloadDebounce = 300 * time.Millisecond
+
+// watchScope watches the directories of every file in paths for changes
+// and triggers a reload of the importer program and oracle when one is
+// modified, created, removed or renamed. If no filesystem notification
+// mechanism is available on the platform, it falls back to polling.
+func watchScope(paths []string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		pollScope(paths)
+		return
+	}
+	defer watcher.Close()
+
+	dirs := make(map[string]bool)
+	for _, p := range paths {
+		dirs[filepath.Dir(p)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil && *verbose {
+			fmt.Fprintf(os.Stderr, "pythia: watch %s: %v\n", dir, err)
+		}
+	}
+
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if timer == nil {
+				timer = time.AfterFunc(reloadDebounce, reload)
+			} else {
+				timer.Reset(reloadDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			if *verbose {
+				fmt.Fprintf(os.Stderr, "pythia: watch error: %v\n", err)
+			}
+		}
+	}
+}
+
+// pollScope periodically re-stats the scope files and reloads when a
+// modification time has changed. It is the fallback for platforms
+// without a working fsnotify backend.
+func pollScope(paths []string) {
+	mtimes := make(map[string]time.Time, len(paths))
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			mtimes[p] = fi.ModTime()
+		}
+	}
+	for range time.Tick(2 * time.Second) {
+		changed := false
+		for _, p := range paths {
+			fi, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+			if !fi.ModTime().Equal(mtimes[p]) {
+				mtimes[p] = fi.ModTime()
+				changed = true
+			}
+		}
+		if changed {
+			reload()
+		}
+	}
+}
+
+// reload rebuilds the oracle's scope and, on success, notifies every
+// open /events subscriber so their browser tab can refresh. Requests
+// already in flight keep being served from the stale program until load
+// returns and swaps the globals in.
+func reload() {
+	if err := load(); err != nil {
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "pythia: reload failed: %v\n", err)
+		}
+		return
+	}
+	notifyReload()
+}
+
+// serveEvents is a Server-Sent Events stream that pushes a notification
+// to the browser whenever the oracle's scope has been reloaded.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	closeNotify, ok := w.(http.CloseNotifier)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := make(chan struct{}, 1)
+	subscribersMu.Lock()
+	subscribers[ch] = true
+	subscribersMu.Unlock()
+	defer func() {
+		subscribersMu.Lock()
+		delete(subscribers, ch)
+		subscribersMu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ch:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-closeNotify.CloseNotify():
+			return
+		}
+	}
+}
+
+// serveReload lets editor plugins trigger an explicit rebuild of the
+// oracle's scope right after a save, instead of waiting for the
+// filesystem watcher to notice.
+func serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	reload()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notifyReload wakes every subscriber of /events.
+func notifyReload() {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
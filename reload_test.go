@@ -0,0 +1,54 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyReload(t *testing.T) {
+	orig := subscribers
+	defer func() { subscribers = orig }()
+	subscribers = make(map[chan struct{}]bool)
+
+	ch := make(chan struct{}, 1)
+	subscribersMu.Lock()
+	subscribers[ch] = true
+	subscribersMu.Unlock()
+
+	notifyReload()
+
+	select {
+	case <-ch:
+	default:
+		t.Error("notifyReload did not notify a registered subscriber")
+	}
+}
+
+func TestNotifyReloadDoesNotBlockOnFullChannel(t *testing.T) {
+	orig := subscribers
+	defer func() { subscribers = orig }()
+	subscribers = make(map[chan struct{}]bool)
+
+	// A subscriber whose buffered channel is already full from a
+	// previous, not-yet-consumed notification.
+	ch := make(chan struct{}, 1)
+	ch <- struct{}{}
+	subscribersMu.Lock()
+	subscribers[ch] = true
+	subscribersMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		notifyReload()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("notifyReload blocked on a subscriber with a full channel")
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"code.google.com/p/go.tools/godoc/vfs"
+	"code.google.com/p/go.tools/godoc/vfs/zipfs"
+)
+
+var (
+	zipPath  = flag.String("zip", "", "Serve a zip archive of the corpus instead of the local filesystem")
+	zipIndex = flag.String("index", "/", "Root path within the zip archive the scope is mounted at")
+)
+
+// fs is the virtual filesystem that pythia reads scope files, directory
+// listings and source through, so the same binary can serve a snapshot
+// of a codebase (read-only demos, CI artefacts, airgapped review) as
+// easily as a checkout on the local disk.
+var fs vfs.FileSystem = vfs.OS("/")
+
+// zipReader is the archive currently backing fs when -zip is set, kept
+// around so scopeBuildContext can close it before opening the next one
+// on reload instead of leaking a file descriptor every time the scope
+// is rebuilt.
+var zipReader *zip.ReadCloser
+
+// scopeBuildContext prepares fs according to the -zip flag and returns a
+// *build.Context whose OpenFile, ReadDir and IsDir are wired to it, so
+// that conf.Load and the oracle it feeds never touch the OS directly.
+func scopeBuildContext() (*build.Context, error) {
+	if *zipPath != "" {
+		rc, err := zip.OpenReader(*zipPath)
+		if err != nil {
+			return nil, err
+		}
+		ns := vfs.NameSpace{}
+		ns.Bind("/", zipfs.New(&rc.Reader, *zipPath), *zipIndex, vfs.BindReplace)
+		fs = ns
+
+		if zipReader != nil {
+			zipReader.Close()
+		}
+		zipReader = rc
+	}
+
+	ctxt := build.Default
+	ctxt.BuildTags = strings.Split(*tags, ",")
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) { return fs.Open(path) }
+	ctxt.ReadDir = func(dir string) ([]os.FileInfo, error) { return fs.ReadDir(dir) }
+	ctxt.IsDir = func(path string) bool {
+		fi, err := fs.Stat(path)
+		return err == nil && fi.IsDir()
+	}
+	return &ctxt, nil
+}
+
+// readSourceFile reads the named scope file through fs, so that
+// serveFile and serveSource render exactly the bytes the oracle itself
+// analysed, whether they came from disk or from the -zip archive. fs
+// is only ever the local filesystem or, with -zip, that archive; no
+// in-memory backend is set up.
+func readSourceFile(name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// inScope reports whether name is one of the files the current oracle
+// scope was built from.
+func inScope(name string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, f := range files {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dirInScope reports whether dir, or some file beneath it, is part of
+// the current oracle scope, so serveFile can filter out directories
+// that don't lead anywhere in scope the same way it filters files.
+func dirInScope(dir string) bool {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, f := range files {
+		if f == dir || strings.HasPrefix(f, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveSource renders the source of the scope file named by the
+// "file" request parameter, reading it through readSourceFile so the
+// bytes shown always match what the oracle analysed, whether -zip is
+// set or not.
+func serveSource(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("file")
+	if !inScope(name) {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := readSourceFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// serveFile lists the directory named by the "dir" request parameter
+// through fs, restricted to entries reachable through the current
+// scope, so browsing a -zip corpus (or the local filesystem when it is
+// unset) never surfaces files the oracle itself cannot see.
+func serveFile(w http.ResponseWriter, r *http.Request) {
+	dir := r.FormValue("dir")
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var names []string
+	for _, fi := range infos {
+		entry := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			if dirInScope(entry) {
+				names = append(names, fi.Name())
+			}
+		} else if inScope(entry) {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(names)
+}
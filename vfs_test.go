@@ -0,0 +1,94 @@
+// Copyright 2013 Frederik Zipp.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestInScope(t *testing.T) {
+	orig := files
+	defer func() { files = orig }()
+	files = []string{"/a/b.go", "/a/c.go"}
+
+	if !inScope("/a/b.go") {
+		t.Error("inScope(/a/b.go) = false, want true")
+	}
+	if inScope("/a/missing.go") {
+		t.Error("inScope(/a/missing.go) = true, want false")
+	}
+}
+
+func TestDirInScope(t *testing.T) {
+	orig := files
+	defer func() { files = orig }()
+	files = []string{"/a/b/c.go"}
+
+	if !dirInScope("/a/b") {
+		t.Error("dirInScope(/a/b) = false, want true (a scope file lies beneath it)")
+	}
+	if !dirInScope("/a/b/c.go") {
+		t.Error("dirInScope(/a/b/c.go) = false, want true (the file itself is in scope)")
+	}
+	if dirInScope("/a/x") {
+		t.Error("dirInScope(/a/x) = true, want false")
+	}
+	if dirInScope("/etc") {
+		t.Error("dirInScope(/etc) = true, want false")
+	}
+}
+
+func TestScopeBuildContextZip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "corpus.zip")
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.Create("hello.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("package hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archive, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	origFS, origZipPath, origZipIndex, origZipReader := fs, *zipPath, *zipIndex, zipReader
+	defer func() {
+		fs = origFS
+		*zipPath = origZipPath
+		*zipIndex = origZipIndex
+		zipReader = origZipReader
+	}()
+	*zipPath = archive
+	*zipIndex = "/"
+
+	ctxt, err := scopeBuildContext()
+	if err != nil {
+		t.Fatalf("scopeBuildContext: %v", err)
+	}
+
+	rc, err := ctxt.OpenFile("/hello.go")
+	if err != nil {
+		t.Fatalf("OpenFile through the zip binding: %v", err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package hello\n"; string(data) != want {
+		t.Errorf("read %q, want %q", data, want)
+	}
+}